@@ -3,27 +3,39 @@
 // Public License that can be found in the LICENSE file.
 
 // morse.go converts text from Stdin to an audio file cw.wav
-//  of Morse Code in signed 16-bit little endian format.
+//  of Morse Code, by default in signed 16-bit little endian format at
+//  11025Hz; see -bits and -rate for other PCM formats and sample rates.
 //  on a linux system: lame cw.wav newFileName.mp3
 //  will write an mp3 file
+//  -play streams straight to the system's default audio output
+//  -stdout writes the wav to stdout, e.g. for piping into lame/ffmpeg/aplay
 
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"io"
 	"math"
 	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+	"unicode"
 )
 
-const rate = 11025 // samples per second
-var dot int        // samples per dot, sets the character speed, 750 is ~ 14 wpm
-var farns float32  // farnsworth dots of quiet added to intervals between chars and (*2) between words
-var bfo int        // audible freqency in Hz, default 660
+var rate int           // samples per second, default 11025
+var bits int           // PCM bit depth: 16 (int), 24 (int) or 32 (IEEE float), default 16
+var bytesPerSample int // bits / 8, kept alongside bits so hot loops don't redivide
+var dot int            // samples per dot, sets the character speed, 750 is ~ 14 wpm at 11025Hz
+var farns float32      // farnsworth dots of quiet added to intervals between chars and (*2) between words
+var bfo int            // audible freqency in Hz, default 660
 
-var morse_code = map[byte]string{
+var morse_code = map[rune]string{
 	'a': ".-", 'b': "-...", 'c': "-.-.",
 	'd': "-..", 'e': ".", 'f': "..-.",
 	'g': "--.", 'h': "....", 'i': "..",
@@ -41,13 +53,24 @@ var morse_code = map[byte]string{
 	'"': ".-..-.", '/': "-..-.", ':': "---...",
 	'\'': ".----.", // according to Consoli
 	'-':  "-....-",
-	'=':  "-...-",  // break/separator     prosign BT (double-dash)
-	'+':  ".-.-.",  // stop/end of message prosign AR
-	'&':  ".-...",  // wait                prosign AS
-	'$':  "...-.-", // end of transmission prosign SK
 	'@':  ".--.-.",
 	' ':  " ", // space (between words) is 4 quiet dots worth, not counting the "std" quiet after char
 	'\n': " ", // end of line equivalent to a space
+	// ITU international extensions to the Latin alphabet
+	'à': ".--.-", 'ä': ".-.-", 'é': "..-..",
+	'ñ': "--.--", 'ö': "---.", 'ü': "..--",
+}
+
+// prosigns holds procedural signals, keyed by name with no brackets or
+// inter-letter gap, sent from input written as e.g. <SK>. See play and
+// the scanner in main for how <...> is recognized.
+var prosigns = map[string]string{
+	"AR":  ".-.-.",  // stop/end of message
+	"AS":  ".-...",  // wait
+	"BT":  "-...-",  // break/separator (double-dash)
+	"KN":  "-.-.-",  // invitation for named station to transmit
+	"SK":  "...-.-", // end of contact
+	"SOS": "...---...",
 }
 
 // As explained by Consoli-------------------------------------------------
@@ -69,86 +92,289 @@ func initClips() {
 	quietSp = quiet(2 + farns)
 }
 
-func play(b []byte) (wav []byte) {
-	for _, ch := range b {
-		seq := morse_code[ch]
-		for _, s := range seq {
-			switch s {
-			case '.':
-				wav = append(wav, tone1...)
-			case '-':
-				wav = append(wav, tone3...)
-			case ' ':
-				wav = append(wav, quietSp...) // between words, std 4, f=2 gives 8
-			default:
-				fmt.Println("problem sounding this character", s)
-				os.Exit(6)
+// token is one scanned unit of input: either a single rune looked up in
+// morse_code, or a bracketed prosign such as <SK> looked up in prosigns.
+// text is how the token should read back in -timing output.
+type token struct {
+	text  string
+	morse string
+}
+
+// nextToken reads the next token from r: a <NAME> prosign, or a single
+// lowercased rune. It returns io.EOF once no token remains.
+func nextToken(r *bufio.Reader) (token, error) {
+	ru, _, err := r.ReadRune()
+	if err != nil {
+		return token{}, err
+	}
+	if ru != '<' {
+		ru = unicode.ToLower(ru)
+		return token{text: string(ru), morse: morse_code[ru]}, nil
+	}
+	var name []rune
+	for {
+		c, _, err := r.ReadRune()
+		if err != nil {
+			// an unterminated <prosign> at EOF still yields whatever name
+			// was scanned so far; any other read error is real and must
+			// be surfaced to the caller, not swallowed as if it were '>'.
+			if err != io.EOF {
+				return token{}, err
 			}
+			break
+		}
+		if c == '>' {
+			break
+		}
+		name = append(name, unicode.ToUpper(c))
+	}
+	text := "<" + string(name) + ">"
+	return token{text: text, morse: prosigns[string(name)]}, nil
+}
+
+// play writes the PCM samples for tok to w, so a caller streaming to a
+// playback sink never has to buffer the whole message. Callers that need
+// the complete waveform (e.g. makeWav) pass a *bytes.Buffer and read back
+// w.Bytes() once done.
+//
+// pos is the running sample count since the start of the transmission
+// (threaded across calls so the stream of tokens stays in sync), and
+// wordStart tracks whether tok begins a new word; both let the returned
+// event line up with -timing sidecar output. ok is false for a token
+// that's just a word gap (space or newline), which has no event of its
+// own to report.
+func play(tok token, w io.Writer, pos *int, wordStart *bool) (ev Event, ok bool) {
+	start := *pos
+	for _, s := range tok.morse {
+		switch s {
+		case '.':
+			w.Write(tone1)
+			*pos += len(tone1) / bytesPerSample
+		case '-':
+			w.Write(tone3)
+			*pos += len(tone3) / bytesPerSample
+		case ' ':
+			w.Write(quietSp) // between words, std 4, f=2 gives 8
+			*pos += len(quietSp) / bytesPerSample
+		default:
+			fmt.Println("problem sounding this character", s)
+			os.Exit(6)
 		}
-		// note this space is also added after ' '
-		wav = append(wav, quietSp...) // between ch, std 2, f=2 gives 4
 	}
-	wav = wav[:]
-	return
+	// note this space is also added after ' '
+	w.Write(quietSp) // between ch, std 2, f=2 gives 4
+	*pos += len(quietSp) / bytesPerSample
+	if tok.text == " " || tok.text == "\n" {
+		*wordStart = true
+		return Event{}, false
+	}
+	ev = Event{Char: tok.text, Morse: tok.morse, StartSample: start, EndSample: *pos, WordStart: *wordStart}
+	*wordStart = false
+	return ev, true
+}
+
+// playbackCmd returns the system command used to stream raw PCM to the
+// default audio output for -play, or nil if none is known for this OS and
+// bit depth.
+func playbackCmd() *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		if bits != 16 {
+			return nil // afplay only takes the formats it was built with; 16-bit is the safe bet
+		}
+		return exec.Command("afplay", "-f", fmt.Sprintf("LEI16@%d", rate), "-")
+	case "linux":
+		alsaFormat := map[int]string{16: "S16_LE", 24: "S24_3LE", 32: "FLOAT_LE"}[bits]
+		return exec.Command("aplay", "-q", "-f", alsaFormat, "-c", "1", "-r", fmt.Sprint(rate), "-t", "raw", "-")
+	default:
+		return nil
+	}
 }
 
 func main() {
 	var wpm, eff int
-	var help bool
+	var help, doPlay, toStdout bool
+	var outName, timingFmt, genMode string
+	var genN, kochN int
+	var seed int64
 	flag.IntVar(&eff, "f", 9, "farnsworth rate, effective wpm")
 	flag.IntVar(&wpm, "w", 22, "character rate, wpm, words per minute")
 	flag.IntVar(&bfo, "t", 660, "Hz for BFO")
 	flag.BoolVar(&help, "h", false, "brief help")
+	flag.BoolVar(&doPlay, "play", false, "stream audio straight to the system's default output instead of (or as well as) writing a wav file")
+	flag.StringVar(&outName, "o", "cw", "base filename for the wav output (written as <name>.wav)")
+	flag.BoolVar(&toStdout, "stdout", false, "write the wav to stdout instead of a file, e.g. for piping into lame/ffmpeg/aplay")
+	flag.IntVar(&rate, "rate", 11025, "samples per second: 8000, 11025, 22050, 44100 or 48000")
+	flag.IntVar(&bits, "bits", 16, "PCM bit depth: 16 (int), 24 (int) or 32 (IEEE float)")
+	flag.StringVar(&timingFmt, "timing", "", "write a sidecar timing file alongside the wav: cue, srt or json")
+	flag.StringVar(&genMode, "gen", "", "generate practice text instead of reading stdin: callsigns, qso or koch")
+	flag.IntVar(&genN, "n", 20, "-gen session length: number of callsigns or koch groups to generate (ignored by qso)")
+	flag.IntVar(&kochN, "koch", 2, "for -gen koch, use only the first N characters of the Koch teaching order")
+	flag.Int64Var(&seed, "seed", 0, "RNG seed for -gen, for a reproducible session; 0 picks a random seed")
 	flag.Parse()
 	helpmsg := "Morse code audio generator, generates cw.wav from stdin text\n" +
-		"  see go code for mapping of prosigns to ascii characters\n" +
+		"  prosigns are written <SK>, <AR>, <BT>, <KN>, <SOS>, etc; see morse_code\n" +
+		"  and prosigns in the go code for the full character/prosign mapping\n" +
 		"  example:\n" +
 		"   go run morse.go <textFile\n" +
 		"  switches:\n" +
 		"   -f  farnsworth rate is the effective words per minute (wpm) sent, default 9\n" +
 		"   -w  character rate, the rate at which individual characters are sent, default 22\n" +
 		"   -t  tone frequency in Hz, default 660\n" +
+		"   -play  stream audio to the system's default output as it's generated\n" +
+		"   -o  base filename for the wav output, default cw\n" +
+		"   -stdout  write the wav to stdout instead of a file\n" +
+		"   -rate  samples per second: 8000, 11025, 22050, 44100 or 48000, default 11025\n" +
+		"   -bits  PCM bit depth: 16, 24 or 32 (IEEE float), default 16\n" +
+		"   -timing  write a cue/srt/json sidecar timing the source characters\n" +
+		"   -gen  generate practice text instead of reading stdin: callsigns, qso or koch\n" +
+		"   -n  -gen session length, default 20\n" +
+		"   -koch  for -gen koch, how many characters of the Koch order to draw from, default 2\n" +
+		"   -seed  RNG seed for -gen, for a reproducible session\n" +
 		"   -h  print this help message\n"
 	if help {
 		fmt.Println(helpmsg)
 		os.Exit(0)
 	}
+	switch rate {
+	case 8000, 11025, 22050, 44100, 48000:
+	default:
+		fmt.Println("-rate must be one of 8000, 11025, 22050, 44100, 48000, got", rate)
+		os.Exit(2)
+	}
+	switch bits {
+	case 16, 24, 32:
+	default:
+		fmt.Println("-bits must be one of 16, 24, 32, got", bits)
+		os.Exit(2)
+	}
+	switch timingFmt {
+	case "", "cue", "srt", "json":
+	default:
+		fmt.Println("-timing must be one of cue, srt, json, got", timingFmt)
+		os.Exit(2)
+	}
+	switch genMode {
+	case "", "callsigns", "qso", "koch":
+	default:
+		fmt.Println("-gen must be one of callsigns, qso, koch, got", genMode)
+		os.Exit(2)
+	}
+	bytesPerSample = bits / 8
 	farns = 50 * float32(wpm-eff) / float32(eff) / 7
-	fmt.Println("farnsworth factor", farns)
-	fmt.Println("digital samples per dot", 13230/wpm)
-	fmt.Println("dot duration in milliseconds", 1200/wpm)
-	dot = int(13230 / wpm)
-	wav := make([]byte, 0)
-	const LEN = 100
-	bailout := false
-	buf := make([]byte, LEN)
+	// these go to stderr, not stdout, since -stdout puts the wav itself on stdout
+	fmt.Fprintln(os.Stderr, "farnsworth factor", farns)
+	dot = int(float64(rate) * 1.2 / float64(wpm))
+	fmt.Fprintln(os.Stderr, "digital samples per dot", dot)
+	fmt.Fprintln(os.Stderr, "dot duration in milliseconds", 1200/wpm)
 	initClips()
 
-	var nr int
-	var err error
+	var playIn io.WriteCloser
+	var playCmd *exec.Cmd
+	playDone := make(chan error, 1)
+	if doPlay {
+		playCmd = playbackCmd()
+		if playCmd == nil {
+			fmt.Println("problem starting playback: no audio player known for", runtime.GOOS, "at", bits, "bits")
+			os.Exit(7)
+		}
+		var err error
+		playIn, err = playCmd.StdinPipe()
+		if err != nil {
+			fmt.Println("problem starting playback", err)
+			os.Exit(7)
+		}
+		playCmd.Stdout = os.Stderr
+		playCmd.Stderr = os.Stderr
+		if err = playCmd.Start(); err != nil {
+			fmt.Println("problem starting playback", err)
+			os.Exit(7)
+		}
+		go func() { playDone <- playCmd.Wait() }()
+	}
+
+	// toStdout can't seek back to patch a wav header, so it still buffers
+	// the whole waveform; writing to a named file streams straight
+	// through WavWriter instead, for constant memory on long messages.
+	var wavBuf bytes.Buffer
+	var wav *WavWriter
+	var dest io.Writer
+	if toStdout {
+		dest = &wavBuf
+	} else {
+		var err error
+		wav, err = NewWavWriter(outName + ".wav")
+		if err != nil {
+			fmt.Println("problem creating", outName+".wav", err)
+			os.Exit(4)
+		}
+		dest = wav
+	}
+	if playIn != nil {
+		dest = io.MultiWriter(dest, playIn)
+	}
+	var in *bufio.Reader
+	if genMode == "" {
+		in = bufio.NewReader(os.Stdin)
+	} else {
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		text, err := generateText(genMode, genN, kochN, seed)
+		if err != nil {
+			fmt.Println("problem generating practice text", err)
+			os.Exit(8)
+		}
+		fmt.Fprintln(os.Stderr, text)
+		in = bufio.NewReader(strings.NewReader(text))
+	}
+	var samplePos int
+	wordStart := true
+	var events []Event
 	for {
-		switch nr, err = os.Stdin.Read(buf); true {
-		case nr > 0:
-			wav = append(wav, play(bytes.ToLower(buf[0:nr]))...)
-		case nr == 0:
-			bailout = true
-		case err != nil:
+		tok, err := nextToken(in)
+		if err != nil {
 			if err != io.EOF {
 				fmt.Println("problem reading standard input", err)
 				os.Exit(3)
 			}
-		}
-		if bailout || err == io.EOF {
 			break
 		}
+		if ev, ok := play(tok, dest, &samplePos, &wordStart); ok {
+			events = append(events, ev)
+		}
+	}
+
+	dest.Write(make([]byte, bytesPerSample)) // trailing sample of silence
+
+	if playIn != nil {
+		playIn.Close()
+		if err := <-playDone; err != nil {
+			fmt.Fprintln(os.Stderr, "playback finished with an error", err)
+		}
+	}
+
+	if toStdout {
+		writeWav(wavBuf.Bytes(), os.Stdout)
+	} else if err := wav.Close(); err != nil {
+		fmt.Println("problem closing", outName+".wav", err)
+		os.Exit(4)
+	}
+
+	if timingFmt != "" {
+		if toStdout {
+			fmt.Fprintln(os.Stderr, "-timing needs a named output file, skipping (wav went to stdout)")
+		} else if err := writeTiming(events, outName, outName+".wav", timingFmt); err != nil {
+			fmt.Println("problem writing timing file", err)
+			os.Exit(5)
+		}
 	}
-	makeWav(wav, "cw")
 }
 
-// tonedur is the number of "dots" of perfect quiet (two bytes per sample)
+// tonedur is the number of "dots" of perfect quiet (bytesPerSample bytes per sample)
 // allow quiet to be called with a float to accomodate Farnsworth factors that aren't integer
 func quiet(tonedur float32) []byte {
-	tone := make([]byte, 2*int(tonedur*float32(dot)))
+	tone := make([]byte, bytesPerSample*int(tonedur*float32(dot)))
 	return tone
 }
 
@@ -156,59 +382,60 @@ func tone(tonedur int) []byte {
 	// sine wave
 	hz := float64(bfo)
 	// rise & fall time in seconds
-	rampTau := 3.0E-3
+	rampTau := 3.0e-3
 	delay := 1.5 * rampTau
-	tone := make([]byte, 2*(tonedur+1)*dot)
+	samples := (tonedur + 1) * dot
+	tone := make([]byte, bytesPerSample*samples)
 	toneSecs := float64(tonedur)*float64(dot)/float64(rate) - rampTau
-	for tics := 0; tics < 2*(tonedur+1)*dot; tics += 2 {
-		seconds := float64(tics) / (2.0 * float64(rate))
+	for i := 0; i < samples; i++ {
+		seconds := float64(i) / float64(rate)
 		cycle := math.Pi * 2.0 * hz * seconds
 		cycle = math.Sin(cycle) // -1..1
 		ramp := (math.Erf((seconds-delay)/rampTau) -
 			math.Erf((seconds-delay-toneSecs)/rampTau)) / 2.0
-		tt := int16(32766 * cycle * ramp)
-		tone[tics] = byte(tt)
-		tone[tics+1] = byte(tt >> 8)
+		writeSample(tone[i*bytesPerSample:], cycle*ramp)
 	}
 	return tone
 }
 
-func bytes4(x uint32) []byte {
-	buf := make([]byte, 4)
-	buf[0] = byte(x >> 0)
-	buf[1] = byte(x >> 8)
-	buf[2] = byte(x >> 16)
-	buf[3] = byte(x >> 24)
-	return buf
+// writeSample encodes v (-1..1) into b at the configured bit depth: signed
+// 16-bit, signed 24-bit (3 bytes, no padding), or 32-bit IEEE float (WAV
+// format code 3), all little endian. Float PCM avoids the quantization
+// noise of int16(32766*v) noticeable near the raised-cosine ramp edges.
+func writeSample(b []byte, v float64) {
+	switch bits {
+	case 16:
+		binary.LittleEndian.PutUint16(b, uint16(int16(32766*v)))
+	case 24:
+		x := int32(8388606 * v)
+		b[0] = byte(x)
+		b[1] = byte(x >> 8)
+		b[2] = byte(x >> 16)
+	case 32:
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v)))
+	}
 }
 
-func makeWav(tone []byte, fn string) {
-	blank := []byte("\x80")
-	export := append(tone, blank...)
-	samples := uint32(len(export))
-	dz := "\x00\x00"
-	sz := "\x00"
-	s1 := "\x01"
-	samp := "\x11\x2b" + dz  // 11025 sample rate (32-bit little endian)
-	samp2 := "\x22\x56" + dz // 2 * 11025 sample rate
-	riff := append(append([]byte("RIFF"), bytes4(samples+36)...), []byte("WAVE")...)
-	//                                   16=>pcm            1=>pcm
-	format := []byte("fmt\x20" + ("\x10" + sz + sz + sz) + (s1 + sz) +
-		// 1 chan    samp   r*ch*b/8     align           bits per samp
-		(s1 + sz) + (samp) + (samp2) + ("\x02" + sz) + ("\x10" + sz))
-	data := append([]byte("data"), bytes4(samples)...)
-	doWrite(fn+".wav", append(append(append(riff, format...), data...), export...))
+// audioFormat is the WAV fmt-chunk format code for the configured bit depth:
+// 1 (WAVE_FORMAT_PCM) for 16/24-bit int, 3 (WAVE_FORMAT_IEEE_FLOAT) for 32-bit.
+func audioFormat() uint16 {
+	if bits == 32 {
+		return 3
+	}
+	return 1
 }
 
-func doWrite(fn string, b []byte) (err error) {
-	f, err := os.Create(fn)
-	if err != nil {
-		return
+// writeWav writes a complete wav (header plus tone, already including its
+// trailing silence sample) to w in one shot. Used for -stdout, which can't
+// seek back to patch a streamed header the way WavWriter does for file
+// output.
+func writeWav(tone []byte, w io.Writer) {
+	if _, err := w.Write(wavHeader(uint32(len(tone)))); err != nil {
+		fmt.Println("problem writing wav", err)
+		os.Exit(4)
 	}
-	defer f.Close()
-	_, err = f.Write(b)
-	if err != nil {
-		return
+	if _, err := w.Write(tone); err != nil {
+		fmt.Println("problem writing wav", err)
+		os.Exit(4)
 	}
-	return
 }
@@ -0,0 +1,147 @@
+// Copyright 2018 David H. Wells, Jr. All rights reserved.
+// Use of this source code is governed by the GNU General
+// Public License that can be found in the LICENSE file.
+
+// timing.go records when each source character was keyed so -timing can
+// export a sidecar cue sheet, SRT subtitle track, or JSON event list for
+// tools that highlight the current character as the wav plays.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Event is one character or prosign as it was keyed into the waveform.
+type Event struct {
+	Char        string // the source character, lowercased
+	Morse       string // the dot/dash pattern sent for Char
+	StartSample int    // sample index the keying starts at
+	EndSample   int    // sample index the keying (and its trailing gap) ends at
+	WordStart   bool   // true if Char is the first character of a word
+}
+
+func (e Event) startMs() int64 { return int64(e.StartSample) * 1000 / int64(rate) }
+func (e Event) endMs() int64   { return int64(e.EndSample) * 1000 / int64(rate) }
+
+// writeTiming writes events to fn in the requested sidecar format: cue,
+// srt or json. wavFn is the wav file the sidecar accompanies.
+func writeTiming(events []Event, fn, wavFn, format string) error {
+	switch format {
+	case "cue":
+		return writeCue(events, fn+".cue", wavFn)
+	case "srt":
+		return writeSRT(events, fn+".srt")
+	case "json":
+		return writeTimingJSON(events, fn+".json")
+	}
+	return fmt.Errorf("unknown timing format %q", format)
+}
+
+// writeCue writes a CD cue sheet with one TRACK per word, indexed to the
+// sample where the word's first character starts keying. wavFn is the
+// wav file the cue sheet points at. TRACK is a 2-digit field, so only the
+// first 99 words get a track; any remainder is dropped with a warning
+// rather than emitting a cue sheet most parsers will reject outright.
+func writeCue(events []Event, fn, wavFn string) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "FILE %q WAVE\n", wavFn)
+	const maxTracks = 99 // cue sheet TRACK is a 2-digit 01-99 index
+	track := 0
+	warned := false
+	var word string
+	var wordEvents []Event
+	emit := func() {
+		if len(wordEvents) == 0 {
+			return
+		}
+		for _, e := range wordEvents {
+			word += e.Char
+		}
+		defer func() { word, wordEvents = "", wordEvents[:0] }()
+		if track >= maxTracks {
+			if !warned {
+				fmt.Fprintf(os.Stderr, "warning: -timing cue supports at most %d tracks; %q and later words are not in %s\n", maxTracks, word, fn)
+				warned = true
+			}
+			return
+		}
+		track++
+		fmt.Fprintf(f, "  TRACK %02d AUDIO\n", track)
+		fmt.Fprintf(f, "    TITLE %q\n", word)
+		fmt.Fprintf(f, "    INDEX 01 %s\n", cueTimecode(wordEvents[0].StartSample))
+	}
+	for _, e := range events {
+		if e.WordStart {
+			emit()
+		}
+		wordEvents = append(wordEvents, e)
+	}
+	emit()
+	return nil
+}
+
+// cueTimecode renders a sample index as a CD cue mm:ss:ff timecode, ff
+// being 1/75s frames as the cue sheet format requires regardless of the
+// wav's actual sample rate.
+func cueTimecode(sample int) string {
+	totalFrames := sample * 75 / rate
+	mm := totalFrames / (75 * 60)
+	ss := (totalFrames / 75) % 60
+	ff := totalFrames % 75
+	return fmt.Sprintf("%02d:%02d:%02d", mm, ss, ff)
+}
+
+// writeSRT writes one subtitle cue per keyed character, showing the
+// character alongside its morse pattern.
+func writeSRT(events []Event, fn string) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for i, e := range events {
+		fmt.Fprintf(f, "%d\n%s --> %s\n%s  (%s)\n\n",
+			i+1, srtTimecode(e.startMs()), srtTimecode(e.endMs()), e.Char, e.Morse)
+	}
+	return nil
+}
+
+func srtTimecode(ms int64) string {
+	h := ms / 3600000
+	m := (ms / 60000) % 60
+	s := (ms / 1000) % 60
+	msRem := ms % 1000
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", h, m, s, msRem)
+}
+
+// writeTimingJSON writes events as a JSON array of {char, startMs, endMs, morse}.
+func writeTimingJSON(events []Event, fn string) error {
+	type jsonEvent struct {
+		Char    string `json:"char"`
+		StartMs int64  `json:"startMs"`
+		EndMs   int64  `json:"endMs"`
+		Morse   string `json:"morse"`
+	}
+	out := make([]jsonEvent, len(events))
+	for i, e := range events {
+		out[i] = jsonEvent{Char: e.Char, StartMs: e.startMs(), EndMs: e.endMs(), Morse: e.Morse}
+	}
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false) // keep prosign angle brackets literal instead of <>-escaped
+	return enc.Encode(out)
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 David H. Wells, Jr. All rights reserved.
+// Use of this source code is governed by the GNU General
+// Public License that can be found in the LICENSE file.
+
+// wavwriter.go streams wav output straight to a file instead of
+// accumulating the whole waveform in a growing []byte, which used to
+// mean repeated large slice reallocations for multi-minute transmissions.
+// It writes the RIFF header with placeholder sizes up front, then patches
+// the RIFF and data chunk sizes by seeking back once the length is known.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"os"
+)
+
+// WavWriter streams PCM samples to a wav file at the package's configured
+// rate/bits, using a single bufio.Writer so tone/quiet chunks go straight
+// through rather than being appended into an in-memory buffer first.
+type WavWriter struct {
+	f         *os.File
+	bw        *bufio.Writer
+	dataBytes uint32
+}
+
+// NewWavWriter creates fn and writes a placeholder RIFF/fmt/data header at
+// the package's current rate/bits, ready for WriteSamples/Write.
+func NewWavWriter(fn string) (*WavWriter, error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		return nil, err
+	}
+	w := &WavWriter{f: f, bw: bufio.NewWriter(f)}
+	if _, err := w.bw.Write(wavHeader(0)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// wavHeader builds the 44-byte RIFF/fmt/data header for dataSize bytes of
+// PCM payload at the package's current rate/bits.
+func wavHeader(dataSize uint32) []byte {
+	const channels = 1
+	blockAlign := uint16(channels * bytesPerSample)
+	hdr := new(bytes.Buffer)
+	hdr.WriteString("RIFF")
+	binary.Write(hdr, binary.LittleEndian, dataSize+36)
+	hdr.WriteString("WAVE")
+	hdr.WriteString("fmt ")
+	binary.Write(hdr, binary.LittleEndian, uint32(16)) // fmt chunk size, PCM
+	binary.Write(hdr, binary.LittleEndian, audioFormat())
+	binary.Write(hdr, binary.LittleEndian, uint16(channels))
+	binary.Write(hdr, binary.LittleEndian, uint32(rate))
+	binary.Write(hdr, binary.LittleEndian, uint32(rate)*uint32(blockAlign)) // byte rate
+	binary.Write(hdr, binary.LittleEndian, blockAlign)
+	binary.Write(hdr, binary.LittleEndian, uint16(bits))
+	hdr.WriteString("data")
+	binary.Write(hdr, binary.LittleEndian, dataSize)
+	return hdr.Bytes()
+}
+
+// Write implements io.Writer so a WavWriter can be play()'s destination
+// directly, alongside or instead of a playback sink.
+func (w *WavWriter) Write(p []byte) (int, error) {
+	n, err := w.bw.Write(p)
+	w.dataBytes += uint32(n)
+	return n, err
+}
+
+// Close patches the RIFF and data chunk sizes now that the total length
+// is known, then closes the underlying file.
+func (w *WavWriter) Close() error {
+	if err := w.bw.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	if _, err := w.f.Seek(0, 0); err != nil {
+		w.f.Close()
+		return err
+	}
+	if _, err := w.f.Write(wavHeader(w.dataBytes)); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
@@ -0,0 +1,45 @@
+// Copyright 2018 David H. Wells, Jr. All rights reserved.
+// Use of this source code is governed by the GNU General
+// Public License that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// BenchmarkWavWriter writes samples through WavWriter the way play()
+// does for a long CW transmission. allocs/op should stay flat no matter
+// how many samples are written, since WavWriter streams straight to a
+// bufio.Writer instead of appending into a growing []byte the way the
+// old accumulate-then-write-the-whole-thing approach did.
+func BenchmarkWavWriter(b *testing.B) {
+	rate, bits, bytesPerSample = 11025, 16, 2
+
+	f, err := os.CreateTemp(b.TempDir(), "bench*.wav")
+	if err != nil {
+		b.Fatal(err)
+	}
+	fn := f.Name()
+	f.Close()
+
+	w, err := NewWavWriter(fn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	chunk := make([]byte, bytesPerSample*100) // a handful of samples, as one tone/quiet clip would be
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := w.Write(chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+}